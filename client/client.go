@@ -10,12 +10,22 @@ import (
 	"time"
 
 	"github.com/andreich/audio/client/recorder"
+	"github.com/andreich/audio/common/interceptors"
 	"github.com/andreich/audio/common/service"
 	"github.com/gordonklaus/portaudio"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 )
 
+// keepaliveParams pings the server periodically so a dead connection is
+// noticed (and redialed) instead of silently dropping samples mid-capture.
+var keepaliveParams = keepalive.ClientParameters{
+	Time:                20 * time.Second,
+	Timeout:             5 * time.Second,
+	PermitWithoutStream: true,
+}
+
 var (
 	address  = flag.String("address", "localhost:9876", "Address to send the recording to.")
 	length   = flag.Duration("length", 5*time.Second, "How long should a chunk be.")
@@ -26,6 +36,9 @@ var (
 	sampleRate  = flag.Int("sample_rate", 44100, "What sample rate to use to record.")
 
 	certificate = flag.String("cert", "client.pem", "What certificate to use to connect to the server.")
+
+	clientID  = flag.String("client_id", "", "Identifier for this client, sent to the server with every stream.")
+	authToken = flag.String("auth_token", "", "Bearer token to authenticate with, if the server requires one.")
 )
 
 // Errorf is a shortcut for logging and exiting with code 1.
@@ -56,7 +69,11 @@ func main() {
 		log.Fatalf("could not load credentials from %q: %v", *certificate, err)
 	}
 
-	conn, err := grpc.Dial(*address, grpc.WithTransportCredentials(creds))
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds), grpc.WithKeepaliveParams(keepaliveParams)}
+	if *authToken != "" {
+		dialOpts = append(dialOpts, grpc.WithChainStreamInterceptor(interceptors.ClientAuthStreamInterceptor(*authToken)))
+	}
+	conn, err := grpc.Dial(*address, dialOpts...)
 	if err != nil {
 		log.Fatalf("could not connect to %q: %v", *address, err)
 	}
@@ -80,7 +97,14 @@ func main() {
 	}
 	log.Printf("IN: %s (max channels=%d; sample rate=%.2f)", devIn.Name, devIn.MaxInputChannels, devIn.DefaultSampleRate)
 
-	rec := recorder.New(client, *length, int32(*numChannels), float32(*sampleRate))
+	id := *clientID
+	if id == "" {
+		if host, err := os.Hostname(); err == nil {
+			id = host
+		}
+	}
+	rec := recorder.New(client, *length, int32(*numChannels), float32(*sampleRate),
+		recorder.WithClientID(id), recorder.WithDeviceName(devIn.Name))
 
 	stream, err := portaudio.OpenStream(portaudio.StreamParameters{
 		Input: portaudio.StreamDeviceParameters{
@@ -4,41 +4,192 @@ package recorder
 
 import (
 	"context"
+	"errors"
 	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/andreich/audio/common/service"
+	"github.com/google/uuid"
 	"github.com/gordonklaus/portaudio"
+	"google.golang.org/grpc/metadata"
 )
 
+// BackoffConfig controls the exponential backoff used to re-establish the
+// RPC stream after it drops.
+type BackoffConfig struct {
+	Base   time.Duration
+	Factor float64
+	Jitter float64
+	Cap    time.Duration
+}
+
+// DefaultBackoff mirrors the standard gRPC connection-backoff parameters.
+var DefaultBackoff = BackoffConfig{
+	Base:   time.Second,
+	Factor: 1.6,
+	Jitter: 0.2,
+	Cap:    120 * time.Second,
+}
+
+// wait returns how long to sleep before the given (0-indexed) retry attempt.
+func (b BackoffConfig) wait(attempt int) time.Duration {
+	d := float64(b.Base) * math.Pow(b.Factor, float64(attempt))
+	if c := float64(b.Cap); d > c {
+		d = c
+	}
+	delta := b.Jitter * d
+	d = d - delta + 2*delta*rand.Float64()
+	return time.Duration(d)
+}
+
+// DefaultRingSize is the number of recent PortAudio frames buffered while a
+// stream is being re-established.
+const DefaultRingSize = 32
+
+// ring is a fixed-capacity FIFO of buffered frames that drops the oldest
+// frame once full, used to cover the samples captured during a reconnect.
+type ring struct {
+	frames [][]float32
+	cap    int
+}
+
+func newRing(cap int) *ring {
+	return &ring{cap: cap}
+}
+
+func (rg *ring) push(frame []float32) {
+	if rg.cap <= 0 {
+		return
+	}
+	cp := make([]float32, len(frame))
+	copy(cp, frame)
+	rg.frames = append(rg.frames, cp)
+	if len(rg.frames) > rg.cap {
+		rg.frames = rg.frames[len(rg.frames)-rg.cap:]
+	}
+}
+
+// drain returns and clears the buffered frames, oldest first.
+func (rg *ring) drain() [][]float32 {
+	out := rg.frames
+	rg.frames = nil
+	return out
+}
+
+// Option configures an R returned by New.
+type Option func(*R)
+
+// WithBackoff overrides the exponential backoff used when reconnecting.
+func WithBackoff(cfg BackoffConfig) Option {
+	return func(r *R) { r.backoff = cfg }
+}
+
+// WithRingSize overrides how many frames are buffered while reconnecting.
+func WithRingSize(n int) Option {
+	return func(r *R) { r.ring = newRing(n) }
+}
+
+// withSleep overrides the function used to wait between reconnect attempts;
+// it exists so tests can assert reconnect behavior without real sleeps.
+func withSleep(sleep func(time.Duration)) Option {
+	return func(r *R) { r.sleep = sleep }
+}
+
+// WithClientID identifies this client to the server across reconnects and
+// sessions (e.g. a hostname or a configured fleet identifier).
+func WithClientID(id string) Option {
+	return func(r *R) { r.clientID = id }
+}
+
+// WithDeviceName records which input device is being recorded, for the
+// server's logs.
+func WithDeviceName(name string) Option {
+	return func(r *R) { r.deviceName = name }
+}
+
+// withSessionID overrides the generated session UUID; it exists so tests
+// can assert on exact metadata.
+func withSessionID(id string) Option {
+	return func(r *R) { r.sessionID = id }
+}
+
 // R is the structure keeping track of current recordings.
 type R struct {
 	client service.RecorderClient
 
+	// mu guards every field below that's also touched by the background
+	// goroutine reconnectInBackground spawns, since Process runs on the
+	// PortAudio audio thread concurrently with it.
+	mu            sync.Mutex
 	currentStream service.Recorder_RecordClient
 	currentFrames int64
+	reconnecting  bool
+	// reconnectDone is closed once the in-flight background reconnect
+	// finishes (successfully or not); tests use it to wait deterministically.
+	reconnectDone chan struct{}
 
 	numChannels int32
 	sampleRate  float32
 	maxLength   time.Duration
+
+	backoff BackoffConfig
+	ring    *ring
+	sleep   func(time.Duration)
+
+	clientID   string
+	deviceName string
+	sessionID  string
+	chunkSeq   int32
 }
 
 // New creates a new recorder sending per stream up to maxLength data.
-func New(client service.RecorderClient, maxLength time.Duration, numChannels int32, sampleRate float32) *R {
-	return &R{
+func New(client service.RecorderClient, maxLength time.Duration, numChannels int32, sampleRate float32, opts ...Option) *R {
+	r := &R{
 		client:      client,
 		numChannels: numChannels,
 		sampleRate:  sampleRate,
 		maxLength:   maxLength,
+		backoff:     DefaultBackoff,
+		ring:        newRing(DefaultRingSize),
+		sleep:       time.Sleep,
+		sessionID:   uuid.New().String(),
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
+}
+
+// streamContext attaches this recorder's session metadata to ctx. resuming
+// is set once a reconnect attempt has had to retry, telling the server to
+// append to the session's existing files rather than start new ones.
+func (r *R) streamContext(ctx context.Context, resuming bool) context.Context {
+	md := metadata.Pairs(
+		"client-id", r.clientID,
+		"device-name", r.deviceName,
+		"session-id", r.sessionID,
+		"chunk-seq", strconv.Itoa(int(r.chunkSeq)),
+	)
+	if resuming {
+		md.Set("resume-from-seq", strconv.Itoa(int(r.chunkSeq)))
+	}
+	return metadata.NewOutgoingContext(ctx, md)
 }
 
 func (r *R) closeCurrentStream() error {
+	r.mu.Lock()
+	stream := r.currentStream
+	r.currentStream = nil
 	r.currentFrames = 0
-	if r.currentStream == nil {
+	r.mu.Unlock()
+	if stream == nil {
 		return nil
 	}
-	return r.currentStream.CloseSend()
+	return stream.CloseSend()
 }
 
 // Close completes the current stream (if it exists).
@@ -46,47 +197,168 @@ func (r *R) Close() error {
 	return r.closeCurrentStream()
 }
 
+// errReconnecting is returned by testAndCloseStream while a background
+// reconnect is still in flight, telling Process to buffer the frame it was
+// about to send instead of dropping it.
+var errReconnecting = errors.New("recorder: reconnecting in the background")
+
+// dial makes a single attempt at opening a new stream; it never sleeps.
+func (r *R) dial(ctx context.Context, attempt int) (service.Recorder_RecordClient, error) {
+	return r.client.Record(r.streamContext(ctx, attempt > 0))
+}
+
+// sendHeaderAndDrain sends stream's header and replays any frames Process
+// buffered into r.ring while the stream was unavailable.
+func (r *R) sendHeaderAndDrain(stream service.Recorder_RecordClient) error {
+	if err := stream.Send(&service.RecordRequest{
+		Header: &service.RecordRequest_Header{
+			NumChannels: r.numChannels,
+			SampleRate:  r.sampleRate,
+		},
+	}); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	frames := r.ring.drain()
+	r.mu.Unlock()
+	for _, frame := range frames {
+		if err := stream.Send(&service.RecordRequest{Sample: frame}); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.currentFrames += int64(len(frame))
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// reconnectInBackground retries dialing with exponential backoff until a
+// stream opens or ctx is cancelled. It must never run on the PortAudio
+// callback thread: the backoff sleeps it does (up to BackoffConfig.Cap) are
+// far too long to tolerate there. Frames captured while it's running are
+// buffered into r.ring by Process and flushed here once the stream is back.
+//
+// r.currentStream is only published once sendHeaderAndDrain has fully sent
+// the header and drained frames: publishing it earlier would let Process,
+// running concurrently on the audio thread, see a connected stream and call
+// Send on it itself - racing with this goroutine's own Sends on the same
+// gRPC stream, and risking a Sample landing before the Header.
+func (r *R) reconnectInBackground(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for attempt := 1; ; attempt++ {
+		stream, err := r.dial(ctx, attempt)
+		if err == nil {
+			if err := r.sendHeaderAndDrain(stream); err != nil {
+				log.Printf("ERROR: couldn't flush buffered frames after reconnecting: %v", err)
+				if ctx.Err() != nil {
+					r.mu.Lock()
+					r.reconnecting = false
+					r.mu.Unlock()
+					return
+				}
+				wait := r.backoff.wait(attempt - 1)
+				log.Printf("ERROR: couldn't open stream (attempt %d): %v; retrying in %v", attempt, err, wait)
+				r.sleep(wait)
+				continue
+			}
+			r.mu.Lock()
+			r.currentStream = stream
+			r.chunkSeq++
+			r.reconnecting = false
+			r.mu.Unlock()
+			return
+		}
+		if ctx.Err() != nil {
+			r.mu.Lock()
+			r.reconnecting = false
+			r.mu.Unlock()
+			return
+		}
+		wait := r.backoff.wait(attempt - 1)
+		log.Printf("ERROR: couldn't open stream (attempt %d): %v; retrying in %v", attempt, err, wait)
+		r.sleep(wait)
+	}
+}
+
+// testAndCloseStream makes sure a usable stream is current, closing and
+// replacing it once maxLength has been exceeded. The replacement is tried
+// once inline - the common case, no outage, succeeds immediately and keeps
+// the audio thread non-blocking - and only falls back to a background retry
+// loop once that single attempt fails, so a flaky server or network never
+// stalls audio capture.
 func (r *R) testAndCloseStream(ctx context.Context, d time.Duration) error {
+	r.mu.Lock()
 	if r.currentStream != nil && r.maxLength >= d {
+		r.mu.Unlock()
 		return nil
 	}
+	if r.reconnecting {
+		r.mu.Unlock()
+		return errReconnecting
+	}
 	log.Printf("current stream frames: %d, length %+v", r.currentFrames, d)
-	err := r.closeCurrentStream()
-	if err != nil {
-		log.Printf("ERROR: couldn't close stream: %v", err)
+	old := r.currentStream
+	r.currentStream = nil
+	r.currentFrames = 0
+	r.mu.Unlock()
+	if old != nil {
+		if err := old.CloseSend(); err != nil {
+			log.Printf("ERROR: couldn't close stream: %v", err)
+		}
 	}
-	r.currentStream, err = r.client.Record(ctx)
-	if err != nil {
-		return err
+
+	if stream, err := r.dial(ctx, 0); err == nil {
+		r.mu.Lock()
+		r.currentStream = stream
+		r.chunkSeq++
+		r.mu.Unlock()
+		return r.sendHeaderAndDrain(stream)
+	} else if ctx.Err() != nil {
+		return ctx.Err()
 	}
-	return r.currentStream.Send(&service.RecordRequest{
-		Header: &service.RecordRequest_Header{
-			NumChannels: r.numChannels,
-			SampleRate:  r.sampleRate,
-		},
-	})
+
+	r.mu.Lock()
+	r.reconnecting = true
+	done := make(chan struct{})
+	r.reconnectDone = done
+	r.mu.Unlock()
+	go r.reconnectInBackground(ctx, done)
+	return errReconnecting
 }
 
 // Process returns a callback suitable for portaudio.
 // From the callback the stream is sent over RPC to the server.
 // Based on the sample rate and num channels the current stream duration is
 // computed, assuming that one call covers one second of recording time.
+// While the stream is being re-established after an error, incoming frames
+// are buffered in a ring instead of being dropped.
 func (r *R) Process(ctx context.Context) func([]float32, []float32, portaudio.StreamCallbackTimeInfo, portaudio.StreamCallbackFlags) {
 	req := &service.RecordRequest{}
 	div := r.sampleRate * float32(r.numChannels)
 	return func(in, _ []float32, timeInfo portaudio.StreamCallbackTimeInfo, flags portaudio.StreamCallbackFlags) {
 		lin := int64(len(in))
-		d := time.Duration(float32(r.currentFrames+lin)/div) * time.Second
+		r.mu.Lock()
+		frames := r.currentFrames
+		r.mu.Unlock()
+		d := time.Duration(float32(frames+lin)/div) * time.Second
 		if err := r.testAndCloseStream(ctx, d); err != nil {
-			log.Printf("ERROR: couldn't test and close the stream: %v", err)
-			log.Printf("ERROR: dropping samples")
+			if err != errReconnecting {
+				log.Printf("ERROR: couldn't test and close the stream: %v", err)
+			}
+			log.Printf("buffering %d samples while reconnecting", lin)
+			r.mu.Lock()
+			r.ring.push(in)
+			r.mu.Unlock()
 			return
 		}
+		r.mu.Lock()
 		r.currentFrames += lin
+		stream := r.currentStream
+		r.mu.Unlock()
 		log.Printf("IN: %d, tI: %+v, f: %+v", lin, timeInfo, flags)
 		req.Reset()
 		req.Sample = append(req.Sample, in...)
-		if err := r.currentStream.Send(req); err != nil {
+		if err := stream.Send(req); err != nil {
 			log.Printf("Stream error: %v", err)
 		}
 	}
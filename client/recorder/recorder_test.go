@@ -2,6 +2,7 @@ package recorder
 
 import (
 	"context"
+	"errors"
 	"math"
 	"testing"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/gordonklaus/portaudio"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 type fakeClient struct {
@@ -18,6 +20,13 @@ type fakeClient struct {
 	idx     int
 	streams []*fakeStream
 	err     error
+
+	// failCount, if positive, makes Record fail with err this many times
+	// before it starts handing out streams - used to exercise reconnect.
+	failCount int
+	attempts  int
+
+	sentMeta []metadata.MD
 }
 
 func (f *fakeClient) verify(t *testing.T) {
@@ -31,10 +40,17 @@ func (f *fakeClient) verify(t *testing.T) {
 }
 
 func (f *fakeClient) Record(ctx context.Context, _ ...grpc.CallOption) (service.Recorder_RecordClient, error) {
-	if f.err != nil {
+	if f.failCount > 0 && f.attempts < f.failCount {
+		f.attempts++
+		return nil, f.err
+	}
+	if f.err != nil && f.failCount == 0 {
 		return nil, f.err
 	}
 	f.idx++
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		f.sentMeta = append(f.sentMeta, md)
+	}
 	return f.streams[f.idx-1], nil
 }
 
@@ -205,3 +221,112 @@ func TestRecorder(t *testing.T) {
 		})
 	}
 }
+
+func TestRecorderReconnect(t *testing.T) {
+	ctx := context.Background()
+
+	dropped := mkSamples(1, 11025, 0)
+	next := mkSamples(1, 11025, 10)
+
+	client := &fakeClient{
+		err: errors.New("transient network error"),
+		// The inline dial in testAndCloseStream consumes the first
+		// failure before the background loop ever starts, so failCount
+		// must exceed the 2 backoff sleeps this test expects by one.
+		failCount: 3,
+		streams: []*fakeStream{
+			{
+				want: []*service.RecordRequest{{
+					Header: &service.RecordRequest_Header{
+						NumChannels: 1,
+						SampleRate:  11025,
+					},
+				}, {
+					Sample: dropped,
+				}, {
+					Sample: next,
+				}},
+			},
+		},
+	}
+
+	var slept []time.Duration
+	r := New(client, 3*time.Second, 1, 11025,
+		withSleep(func(d time.Duration) { slept = append(slept, d) }))
+	cb := r.Process(ctx)
+
+	// The first call's inline dial fails, so the frame must be buffered
+	// instead of dropped while a background goroutine keeps retrying.
+	cb(dropped, nil, portaudio.StreamCallbackTimeInfo{}, 0)
+	r.mu.Lock()
+	done := r.reconnectDone
+	r.mu.Unlock()
+	if done == nil {
+		t.Fatal("expected a background reconnect to have started")
+	}
+	// Wait for the background loop to exhaust failCount, reconnect, and
+	// flush the buffered frame - deterministic even with a no-op sleep,
+	// since it's the real goroutine scheduling we're waiting on, not time.
+	<-done
+
+	// Now that a stream is current again, later calls go straight through.
+	cb(next, nil, portaudio.StreamCallbackTimeInfo{}, 0)
+	r.Close()
+
+	client.verify(t)
+	if len(slept) != 2 {
+		t.Errorf("got %d backoff sleeps, want 2", len(slept))
+	}
+}
+
+func TestRecorderStreamMetadata(t *testing.T) {
+	ctx := context.Background()
+	samples := mkSamples(1, 11025, 0)
+
+	client := &fakeClient{
+		streams: []*fakeStream{
+			{want: []*service.RecordRequest{{
+				Header: &service.RecordRequest_Header{NumChannels: 1, SampleRate: 11025},
+			}, {
+				Sample: samples,
+			}}},
+			{want: []*service.RecordRequest{{
+				Header: &service.RecordRequest_Header{NumChannels: 1, SampleRate: 11025},
+			}, {
+				Sample: samples,
+			}}},
+		},
+	}
+
+	r := New(client, time.Second, 1, 11025,
+		WithClientID("rpi-kitchen"), WithDeviceName("USB Mic"), withSessionID("test-session"))
+	cb := r.Process(ctx)
+	cb(samples, nil, portaudio.StreamCallbackTimeInfo{}, 0)
+	cb(samples, nil, portaudio.StreamCallbackTimeInfo{}, 0) // forces a new stream: maxLength exceeded
+	r.Close()
+
+	if len(client.sentMeta) != 2 {
+		t.Fatalf("got %d streams opened, want 2", len(client.sentMeta))
+	}
+	for i, want := range []struct {
+		chunkSeq string
+		resume   bool
+	}{{"0", false}, {"1", false}} {
+		md := client.sentMeta[i]
+		if got := md.Get("client-id"); len(got) != 1 || got[0] != "rpi-kitchen" {
+			t.Errorf("stream %d client-id = %v, want [rpi-kitchen]", i, got)
+		}
+		if got := md.Get("device-name"); len(got) != 1 || got[0] != "USB Mic" {
+			t.Errorf("stream %d device-name = %v, want [USB Mic]", i, got)
+		}
+		if got := md.Get("session-id"); len(got) != 1 || got[0] != "test-session" {
+			t.Errorf("stream %d session-id = %v, want [test-session]", i, got)
+		}
+		if got := md.Get("chunk-seq"); len(got) != 1 || got[0] != want.chunkSeq {
+			t.Errorf("stream %d chunk-seq = %v, want [%s]", i, got, want.chunkSeq)
+		}
+		if got := md.Get("resume-from-seq"); want.resume != (len(got) > 0) {
+			t.Errorf("stream %d resume-from-seq = %v, want present=%v", i, got, want.resume)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeServerStream is a minimal grpc.ServerStream for exercising
+// interceptors without a real connection.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	msgs []interface{}
+	idx  int
+}
+
+func (f *fakeServerStream) Context() context.Context { return f.ctx }
+
+func (f *fakeServerStream) RecvMsg(m interface{}) error {
+	if f.idx >= len(f.msgs) {
+		return io.EOF
+	}
+	msg := f.msgs[f.idx]
+	f.idx++
+	out, ok := m.(*fakeRequest)
+	if !ok {
+		return errors.New("fakeServerStream: unexpected message type")
+	}
+	*out = *msg.(*fakeRequest)
+	return nil
+}
+
+// fakeRequest stands in for *service.RecordRequest for tests, implementing
+// the same sampleCounter/sizer duck-types the interceptors look for.
+type fakeRequest struct {
+	sample []float32
+}
+
+func (r *fakeRequest) GetSample() []float32 { return r.sample }
+func (r *fakeRequest) Size() int            { return len(r.sample) * 4 }
+
+func drain(ss grpc.ServerStream) error {
+	for {
+		var req fakeRequest
+		if err := ss.RecvMsg(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func TestAuthStreamInterceptor(t *testing.T) {
+	interceptor := AuthStreamInterceptor([]string{"good-token"})
+	info := &grpc.StreamServerInfo{FullMethod: "/Recorder/Record"}
+	handlerCalled := false
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		handlerCalled = true
+		return nil
+	}
+
+	for _, tc := range []struct {
+		desc    string
+		md      metadata.MD
+		wantErr codes.Code
+	}{
+		{desc: "missing metadata", md: nil, wantErr: codes.Unauthenticated},
+		{desc: "missing authorization key", md: metadata.Pairs("x", "y"), wantErr: codes.Unauthenticated},
+		{desc: "not a bearer token", md: metadata.Pairs("authorization", "good-token"), wantErr: codes.Unauthenticated},
+		{desc: "unknown token", md: metadata.Pairs("authorization", "Bearer bad-token"), wantErr: codes.Unauthenticated},
+		{desc: "valid token", md: metadata.Pairs("authorization", "Bearer good-token"), wantErr: codes.OK},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			handlerCalled = false
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+			ss := &fakeServerStream{ctx: ctx}
+			err := interceptor(nil, ss, info, handler)
+			if tc.wantErr == codes.OK {
+				if err != nil {
+					t.Fatalf("got error %v, want nil", err)
+				}
+				if !handlerCalled {
+					t.Fatal("handler was not called")
+				}
+				return
+			}
+			if status.Code(err) != tc.wantErr {
+				t.Fatalf("got code %v, want %v", status.Code(err), tc.wantErr)
+			}
+			if handlerCalled {
+				t.Fatal("handler should not have been called")
+			}
+		})
+	}
+}
+
+func TestLoggingStreamInterceptor(t *testing.T) {
+	interceptor := LoggingStreamInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/Recorder/Record"}
+
+	var seenBytes, seenSamples int64
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		err := drain(ss)
+		css := ss.(*countingServerStream)
+		seenBytes, seenSamples = css.bytes, css.samples
+		return err
+	}
+
+	ss := &fakeServerStream{
+		ctx: context.Background(),
+		msgs: []interface{}{
+			&fakeRequest{sample: make([]float32, 10)},
+			&fakeRequest{sample: make([]float32, 5)},
+		},
+	}
+	if err := interceptor(nil, ss, info, handler); err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+	if seenSamples != 15 {
+		t.Errorf("samples = %d, want 15", seenSamples)
+	}
+	if seenBytes != 60 {
+		t.Errorf("bytes = %d, want 60", seenBytes)
+	}
+}
+
+func TestMetricsStreamInterceptor(t *testing.T) {
+	m := NewMetrics()
+	interceptor := m.StreamInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/Recorder/Record"}
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		return drain(ss)
+	}
+
+	ss := &fakeServerStream{
+		ctx:  context.Background(),
+		msgs: []interface{}{&fakeRequest{sample: make([]float32, 8)}},
+	}
+	if err := interceptor(nil, ss, info, handler); err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+
+	metricFamilies, err := m.reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	values := map[string]float64{}
+	for _, mf := range metricFamilies {
+		values[mf.GetName()] = mf.GetMetric()[0].GetCounter().GetValue()
+	}
+	if values["recorder_streams_opened_total"] != 1 {
+		t.Errorf("streams_opened = %v, want 1", values["recorder_streams_opened_total"])
+	}
+	if values["recorder_streams_closed_total"] != 1 {
+		t.Errorf("streams_closed = %v, want 1", values["recorder_streams_closed_total"])
+	}
+	if values["recorder_samples_written_total"] != 8 {
+		t.Errorf("samples_written = %v, want 8", values["recorder_samples_written_total"])
+	}
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	interceptor := AuthUnaryInterceptor([]string{"good-token"})
+	info := &grpc.UnaryServerInfo{FullMethod: "/Control/GetSession"}
+	handlerCalled := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		desc    string
+		md      metadata.MD
+		wantErr codes.Code
+	}{
+		{desc: "missing metadata", md: nil, wantErr: codes.Unauthenticated},
+		{desc: "missing authorization key", md: metadata.Pairs("x", "y"), wantErr: codes.Unauthenticated},
+		{desc: "not a bearer token", md: metadata.Pairs("authorization", "good-token"), wantErr: codes.Unauthenticated},
+		{desc: "unknown token", md: metadata.Pairs("authorization", "Bearer bad-token"), wantErr: codes.Unauthenticated},
+		{desc: "valid token", md: metadata.Pairs("authorization", "Bearer good-token"), wantErr: codes.OK},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			handlerCalled = false
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+			_, err := interceptor(ctx, nil, info, handler)
+			if tc.wantErr == codes.OK {
+				if err != nil {
+					t.Fatalf("got error %v, want nil", err)
+				}
+				if !handlerCalled {
+					t.Fatal("handler was not called")
+				}
+				return
+			}
+			if status.Code(err) != tc.wantErr {
+				t.Fatalf("got code %v, want %v", status.Code(err), tc.wantErr)
+			}
+			if handlerCalled {
+				t.Fatal("handler should not have been called")
+			}
+		})
+	}
+}
+
+func TestLoggingUnaryInterceptor(t *testing.T) {
+	interceptor := LoggingUnaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/Control/GetSession"}
+	handlerCalled := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		return "ok", nil
+	}
+
+	resp, err := interceptor(context.Background(), nil, info, handler)
+	if err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+	if !handlerCalled {
+		t.Fatal("handler was not called")
+	}
+	if resp != "ok" {
+		t.Errorf("resp = %v, want ok", resp)
+	}
+}
+
+func TestMetricsUnaryInterceptor(t *testing.T) {
+	m := NewMetrics()
+	interceptor := m.UnaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/Control/GetSession"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+
+	metricFamilies, err := m.reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	values := map[string]float64{}
+	for _, mf := range metricFamilies {
+		values[mf.GetName()] = mf.GetMetric()[0].GetCounter().GetValue()
+	}
+	if values["recorder_unary_calls_handled_total"] != 1 {
+		t.Errorf("unary_calls_handled = %v, want 1", values["recorder_unary_calls_handled_total"])
+	}
+}
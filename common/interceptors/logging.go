@@ -0,0 +1,77 @@
+package interceptors
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// sampleCounter is implemented by request messages that carry samples worth
+// tallying in the stream summary (currently *service.RecordRequest).
+type sampleCounter interface {
+	GetSample() []float32
+}
+
+// sizer is implemented by generated proto messages.
+type sizer interface {
+	Size() int
+}
+
+// countingServerStream wraps a grpc.ServerStream to tally the bytes and
+// samples received over its lifetime.
+type countingServerStream struct {
+	grpc.ServerStream
+	bytes   int64
+	samples int64
+}
+
+func (s *countingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	if sz, ok := m.(sizer); ok {
+		s.bytes += int64(sz.Size())
+	}
+	if sc, ok := m.(sampleCounter); ok {
+		s.samples += int64(len(sc.GetSample()))
+	}
+	return nil
+}
+
+// LoggingStreamInterceptor logs one structured record when a stream
+// closes: method, peer, duration, bytes received and sample count.
+func LoggingStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		css := &countingServerStream{ServerStream: ss}
+		err := handler(srv, css)
+		p, _ := peer.FromContext(ss.Context())
+		log.Printf("stream closed: method=%s peer=%s duration=%v bytes=%d samples=%d err=%v",
+			info.FullMethod, peerAddr(p), time.Since(start), css.bytes, css.samples, err)
+		return err
+	}
+}
+
+// LoggingUnaryInterceptor logs one structured record when a unary call
+// returns: method, peer, duration and error.
+func LoggingUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		p, _ := peer.FromContext(ctx)
+		log.Printf("call finished: method=%s peer=%s duration=%v err=%v",
+			info.FullMethod, peerAddr(p), time.Since(start), err)
+		return resp, err
+	}
+}
+
+func peerAddr(p *peer.Peer) string {
+	if p == nil || p.Addr == nil {
+		return "unknown"
+	}
+	return p.Addr.String()
+}
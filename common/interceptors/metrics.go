@@ -0,0 +1,99 @@
+package interceptors
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+)
+
+// Metrics holds the Prometheus collectors exported for the Recorder
+// service.
+type Metrics struct {
+	reg *prometheus.Registry
+
+	StreamsOpened  prometheus.Counter
+	StreamsClosed  prometheus.Counter
+	SamplesWritten prometheus.Counter
+	EncoderErrors  prometheus.Counter
+	StreamDuration prometheus.Histogram
+
+	UnaryCallsHandled prometheus.Counter
+	UnaryCallDuration prometheus.Histogram
+}
+
+// NewMetrics registers a fresh set of Recorder collectors on their own
+// registry, so Handler only ever exports this service's metrics.
+func NewMetrics() *Metrics {
+	reg := prometheus.NewRegistry()
+	factory := promauto.With(reg)
+	return &Metrics{
+		reg: reg,
+		StreamsOpened: factory.NewCounter(prometheus.CounterOpts{
+			Name: "recorder_streams_opened_total",
+			Help: "Number of Record streams opened.",
+		}),
+		StreamsClosed: factory.NewCounter(prometheus.CounterOpts{
+			Name: "recorder_streams_closed_total",
+			Help: "Number of Record streams closed.",
+		}),
+		SamplesWritten: factory.NewCounter(prometheus.CounterOpts{
+			Name: "recorder_samples_written_total",
+			Help: "Number of audio samples written to disk.",
+		}),
+		EncoderErrors: factory.NewCounter(prometheus.CounterOpts{
+			Name: "recorder_encoder_errors_total",
+			Help: "Number of errors returned by an output encoder.",
+		}),
+		StreamDuration: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "recorder_stream_duration_seconds",
+			Help:    "Duration of a Record stream from open to close.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		UnaryCallsHandled: factory.NewCounter(prometheus.CounterOpts{
+			Name: "recorder_unary_calls_handled_total",
+			Help: "Number of unary RPCs (e.g. Control) handled.",
+		}),
+		UnaryCallDuration: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "recorder_unary_call_duration_seconds",
+			Help:    "Duration of a unary RPC call.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+}
+
+// StreamInterceptor tracks streams opened/closed, samples written and
+// stream duration. Encoder errors aren't visible to a generic interceptor,
+// so the server increments m.EncoderErrors itself where they occur.
+func (m *Metrics) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		m.StreamsOpened.Inc()
+		css := &countingServerStream{ServerStream: ss}
+		err := handler(srv, css)
+		m.SamplesWritten.Add(float64(css.samples))
+		m.StreamDuration.Observe(time.Since(start).Seconds())
+		m.StreamsClosed.Inc()
+		return err
+	}
+}
+
+// UnaryInterceptor tracks unary calls handled and their duration.
+func (m *Metrics) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.UnaryCallDuration.Observe(time.Since(start).Seconds())
+		m.UnaryCallsHandled.Inc()
+		return resp, err
+	}
+}
+
+// Handler serves the registered collectors; mount it at /metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,80 @@
+// Package encoder implements the pluggable output encoders used by the
+// recording server: WAV (PCM16 or float32), FLAC and Opus.
+package encoder
+
+import "fmt"
+
+// Format identifies which on-disk encoding to use for a recording.
+type Format string
+
+const (
+	// FormatWAVPCM16 writes 16-bit PCM samples in a WAV container. This is
+	// the server's historical behavior.
+	FormatWAVPCM16 Format = "wav-pcm16"
+	// FormatWAVFloat32 writes 32-bit float samples in a WAV container,
+	// preserving full dynamic range for archival use.
+	FormatWAVFloat32 Format = "wav-float32"
+	// FormatFLAC losslessly compresses samples with FLAC.
+	FormatFLAC Format = "flac"
+	// FormatOpus lossily compresses samples with Opus, for
+	// bandwidth-constrained links.
+	FormatOpus Format = "opus"
+)
+
+// DefaultFormat is used when neither the --format flag nor the stream
+// header request a specific encoding.
+const DefaultFormat = FormatWAVPCM16
+
+// Extension returns the file extension conventionally used for f.
+func (f Format) Extension() string {
+	switch f {
+	case FormatFLAC:
+		return "flac"
+	case FormatOpus:
+		return "opus"
+	default:
+		return "wav"
+	}
+}
+
+// Encoder writes interleaved float32 samples to an output file.
+type Encoder interface {
+	// Write encodes and appends samples, returning how many frames were
+	// written.
+	Write(samples []float32) (int64, error)
+	// Close flushes any buffered data and closes the underlying file.
+	Close() error
+}
+
+// Factory creates an Encoder for a given output path, channel count and
+// sample rate.
+type Factory interface {
+	New(path string, numChannels, sampleRate int32) (Encoder, error)
+}
+
+// FactoryFunc adapts a plain function to a Factory.
+type FactoryFunc func(path string, numChannels, sampleRate int32) (Encoder, error)
+
+// New implements Factory.
+func (f FactoryFunc) New(path string, numChannels, sampleRate int32) (Encoder, error) {
+	return f(path, numChannels, sampleRate)
+}
+
+// FactoryFor returns the Factory implementing format, or an error if the
+// format isn't recognized. It's the single place that knows how the
+// Format values on the wire (--format flag, RecordRequest_Header.Format)
+// map to concrete encoders.
+func FactoryFor(format Format) (Factory, error) {
+	switch format {
+	case "", FormatWAVPCM16:
+		return FactoryFunc(newWAVPCM16), nil
+	case FormatWAVFloat32:
+		return FactoryFunc(newWAVFloat32), nil
+	case FormatFLAC:
+		return FactoryFunc(newFLAC), nil
+	case FormatOpus:
+		return FactoryFunc(newOpus), nil
+	default:
+		return nil, fmt.Errorf("encoder: unknown format %q", format)
+	}
+}
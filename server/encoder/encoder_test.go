@@ -0,0 +1,172 @@
+package encoder
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hraban/opus"
+	"github.com/mkb218/gosndfile/sndfile"
+)
+
+// sineWave generates numFrames samples (mono) of a sine at freqHz.
+func sineWave(numFrames int, sampleRate, freqHz float64) []float32 {
+	out := make([]float32, numFrames)
+	for i := range out {
+		out[i] = float32(math.Sin(2 * math.Pi * freqHz * float64(i) / sampleRate))
+	}
+	return out
+}
+
+func readSndfile(t *testing.T, path string, numFrames int) []float32 {
+	t.Helper()
+	info := &sndfile.Info{}
+	f, err := sndfile.Open(path, sndfile.Read, info)
+	if err != nil {
+		t.Fatalf("sndfile.Open(%q): %v", path, err)
+	}
+	defer f.Close()
+	out := make([]float32, numFrames)
+	n, err := f.ReadFrames(out)
+	if err != nil && err != io.EOF {
+		t.Fatalf("ReadFrames: %v", err)
+	}
+	return out[:n]
+}
+
+func readOpus(t *testing.T, path string, sampleRate, numChannels, frameLen int) []float32 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open(%q): %v", path, err)
+	}
+	defer f.Close()
+
+	dec, err := opus.NewDecoder(sampleRate, numChannels)
+	if err != nil {
+		t.Fatalf("opus.NewDecoder: %v", err)
+	}
+
+	var out []float32
+	pcm := make([]float32, frameLen*numChannels)
+	for {
+		var n uint32
+		if err := binary.Read(f, binary.LittleEndian, &n); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("binary.Read: %v", err)
+		}
+		packet := make([]byte, n)
+		if _, err := io.ReadFull(f, packet); err != nil {
+			t.Fatalf("read packet: %v", err)
+		}
+		samples, err := dec.DecodeFloat32(packet, pcm)
+		if err != nil {
+			t.Fatalf("DecodeFloat32: %v", err)
+		}
+		out = append(out, pcm[:samples*numChannels]...)
+	}
+	return out
+}
+
+// bestCorrelation searches lags in [-maxLag, maxLag] for the offset of b
+// relative to a that maximizes their normalized cross-correlation, and
+// returns that lag and correlation (1.0 is a perfect match). It's used to
+// compare a lossy codec's output against the original signal without
+// assuming they're sample-aligned.
+func bestCorrelation(a, b []float32, maxLag int) (lag int, corr float64) {
+	best := -1.0
+	for l := -maxLag; l <= maxLag; l++ {
+		var dot, na, nb float64
+		for i := range a {
+			j := i + l
+			if j < 0 || j >= len(b) {
+				continue
+			}
+			dot += float64(a[i]) * float64(b[j])
+			na += float64(a[i]) * float64(a[i])
+			nb += float64(b[j]) * float64(b[j])
+		}
+		if na == 0 || nb == 0 {
+			continue
+		}
+		c := dot / math.Sqrt(na*nb)
+		if c > best {
+			best, lag = c, l
+		}
+	}
+	return lag, best
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	const (
+		sampleRate = 48000
+		numFrames  = 4800 // 100ms
+	)
+	wave := sineWave(numFrames, sampleRate, 440)
+
+	for _, tc := range []struct {
+		format    Format
+		tolerance float32
+	}{
+		{FormatWAVPCM16, 1.0 / 32768 * 2}, // two PCM16 quantization steps
+		{FormatWAVFloat32, 1e-6},
+		{FormatFLAC, 1.0 / 32768 * 2},
+		{FormatOpus, 0.25}, // lossy - only gross shape is guaranteed
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			factory, err := FactoryFor(tc.format)
+			if err != nil {
+				t.Fatalf("FactoryFor(%v): %v", tc.format, err)
+			}
+			path := filepath.Join(t.TempDir(), "out."+tc.format.Extension())
+			enc, err := factory.New(path, 1, sampleRate)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if _, err := enc.Write(wave); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			var got []float32
+			if tc.format == FormatOpus {
+				got = readOpus(t, path, sampleRate, 1, sampleRate*opusFrameMs/1000)
+			} else {
+				got = readSndfile(t, path, numFrames)
+			}
+			if len(got) < numFrames {
+				t.Fatalf("got %d frames back, want at least %d", len(got), numFrames)
+			}
+			if tc.format == FormatOpus {
+				// Opus shifts the signal by its algorithmic delay and
+				// lookahead (a few hundred samples at 48kHz) and is lossy,
+				// so a sample-for-sample diff doesn't apply; cross-correlate
+				// over a generous lag window instead and require the
+				// best-aligned match to be strong.
+				lag, corr := bestCorrelation(wave, got, 2*sampleRate*opusFrameMs/1000)
+				if corr < 0.98 {
+					t.Fatalf("best correlation %.4f at lag %d samples, want >= 0.98", corr, lag)
+				}
+				return
+			}
+			for i := range wave {
+				if diff := math.Abs(float64(got[i] - wave[i])); diff > float64(tc.tolerance) {
+					t.Fatalf("sample %d: got %v, want %v (diff %v > tolerance %v)", i, got[i], wave[i], diff, tc.tolerance)
+				}
+			}
+		})
+	}
+}
+
+func TestFactoryForUnknownFormat(t *testing.T) {
+	if _, err := FactoryFor("bogus"); err == nil {
+		t.Fatal("FactoryFor(bogus): want error, got nil")
+	}
+}
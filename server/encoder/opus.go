@@ -0,0 +1,92 @@
+package encoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/hraban/opus"
+)
+
+// opusFrameMs is the frame duration we encode at; Opus only accepts 2.5, 5,
+// 10, 20, 40 or 60ms frames.
+const opusFrameMs = 20
+
+// maxOpusPacket is a generous upper bound for a single encoded frame.
+const maxOpusPacket = 4000
+
+// opusEncoder buffers interleaved samples until a full frame is available,
+// encodes it, and writes the result as a simple
+// [uint32 length][packet bytes] stream.
+type opusEncoder struct {
+	enc      *opus.Encoder
+	f        *os.File
+	channels int
+	frameLen int // samples per channel per frame
+	buf      []float32
+	scratch  []byte
+}
+
+func newOpus(path string, numChannels, sampleRate int32) (Encoder, error) {
+	enc, err := opus.NewEncoder(int(sampleRate), int(numChannels), opus.AppAudio)
+	if err != nil {
+		return nil, fmt.Errorf("encoder: opus: %w", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &opusEncoder{
+		enc:      enc,
+		f:        f,
+		channels: int(numChannels),
+		frameLen: int(sampleRate) * opusFrameMs / 1000,
+		scratch:  make([]byte, maxOpusPacket),
+	}, nil
+}
+
+// flushFrame encodes exactly one full frame from e.buf and resets it.
+func (e *opusEncoder) flushFrame() (int64, error) {
+	n, err := e.enc.EncodeFloat32(e.buf, e.scratch)
+	if err != nil {
+		return 0, fmt.Errorf("encoder: opus: %w", err)
+	}
+	if err := binary.Write(e.f, binary.LittleEndian, uint32(n)); err != nil {
+		return 0, err
+	}
+	if _, err := e.f.Write(e.scratch[:n]); err != nil {
+		return 0, err
+	}
+	e.buf = nil
+	return int64(e.frameLen), nil
+}
+
+func (e *opusEncoder) Write(samples []float32) (int64, error) {
+	e.buf = append(e.buf, samples...)
+	frameSize := e.frameLen * e.channels
+	var written int64
+	for len(e.buf) >= frameSize {
+		frame := e.buf[:frameSize]
+		rest := e.buf[frameSize:]
+		e.buf = frame
+		n, err := e.flushFrame()
+		if err != nil {
+			return written, err
+		}
+		written += n
+		e.buf = append([]float32(nil), rest...)
+	}
+	return written, nil
+}
+
+func (e *opusEncoder) Close() error {
+	if len(e.buf) > 0 {
+		frameSize := e.frameLen * e.channels
+		e.buf = append(e.buf, make([]float32, frameSize-len(e.buf))...)
+		if _, err := e.flushFrame(); err != nil {
+			e.f.Close()
+			return err
+		}
+	}
+	return e.f.Close()
+}
@@ -0,0 +1,42 @@
+package encoder
+
+import "github.com/mkb218/gosndfile/sndfile"
+
+// sndfileEncoder adapts a *sndfile.File, used for WAV/PCM16, WAV/Float32 and
+// FLAC, to the Encoder interface.
+type sndfileEncoder struct {
+	f *sndfile.File
+}
+
+func (e *sndfileEncoder) Write(samples []float32) (int64, error) {
+	return e.f.WriteFrames(samples)
+}
+
+func (e *sndfileEncoder) Close() error {
+	return e.f.Close()
+}
+
+func newSndfile(path string, numChannels, sampleRate int32, format int32) (Encoder, error) {
+	info := &sndfile.Info{
+		Channels:   numChannels,
+		Samplerate: sampleRate,
+		Format:     format,
+	}
+	f, err := sndfile.Open(path, sndfile.Write, info)
+	if err != nil {
+		return nil, err
+	}
+	return &sndfileEncoder{f: f}, nil
+}
+
+func newWAVPCM16(path string, numChannels, sampleRate int32) (Encoder, error) {
+	return newSndfile(path, numChannels, sampleRate, sndfile.SF_FORMAT_WAV|sndfile.SF_FORMAT_PCM_16)
+}
+
+func newWAVFloat32(path string, numChannels, sampleRate int32) (Encoder, error) {
+	return newSndfile(path, numChannels, sampleRate, sndfile.SF_FORMAT_WAV|sndfile.SF_FORMAT_FLOAT)
+}
+
+func newFLAC(path string, numChannels, sampleRate int32) (Encoder, error) {
+	return newSndfile(path, numChannels, sampleRate, sndfile.SF_FORMAT_FLAC|sndfile.SF_FORMAT_PCM_16)
+}
@@ -1,69 +1,418 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/andreich/audio/common/interceptors"
 	"github.com/andreich/audio/common/service"
+	"github.com/andreich/audio/server/encoder"
+	"github.com/andreich/audio/server/vad"
 	"github.com/golang/protobuf/proto"
-	"github.com/mkb218/gosndfile/sndfile"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	bind        = flag.String("bind", "localhost:9876", "Address to bind to.")
 	certificate = flag.String("cert", "server.pem", "What certificate to use to connect to the server.")
+	format      = flag.String("format", string(encoder.DefaultFormat), "Default output encoding: wav-pcm16, wav-float32, flac or opus. A client can request a different one per stream via the header.")
+
+	authTokens  = flag.String("auth_tokens", "", "Comma-separated list of bearer tokens clients may authenticate with. Empty disables auth.")
+	metricsBind = flag.String("metrics_bind", ":9877", "Address to serve Prometheus metrics (/metrics) on.")
+
+	vadEnabled = flag.Bool("vad", false, "Split every stream into per-utterance files at silence boundaries. A client can also request this per stream via the header.")
 )
 
+// keepaliveEnforcement rejects clients that ping more often than every 10s
+// (half the interval of our own keepalive.ClientParameters), to guard
+// against abusive peers.
+var keepaliveEnforcement = keepalive.EnforcementPolicy{
+	MinTime:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+// keepaliveParams mirrors the client's keepalive settings so idle streams
+// are pinged and dead connections are detected promptly.
+var keepaliveParams = keepalive.ServerParameters{
+	Time:    20 * time.Second,
+	Timeout: 5 * time.Second,
+}
+
+// streamMeta is the per-RPC metadata a client attaches to a Record call so
+// the server can correlate it with a client, device and logical session.
+type streamMeta struct {
+	clientID   string
+	deviceName string
+	sessionID  string
+	chunkSeq   int32
+	// resumeSeq is the chunk a reconnecting client wants the server to
+	// keep appending after, or -1 if this is a fresh stream.
+	resumeSeq int32
+}
+
+func streamMetaFromContext(ctx context.Context) streamMeta {
+	m := streamMeta{clientID: "unknown", resumeSeq: -1}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return m
+	}
+	if v := md.Get("client-id"); len(v) > 0 && v[0] != "" {
+		m.clientID = v[0]
+	}
+	if v := md.Get("device-name"); len(v) > 0 {
+		m.deviceName = v[0]
+	}
+	if v := md.Get("session-id"); len(v) > 0 {
+		m.sessionID = v[0]
+	}
+	if v := md.Get("chunk-seq"); len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil {
+			m.chunkSeq = int32(n)
+		}
+	}
+	if v := md.Get("resume-from-seq"); len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil {
+			m.resumeSeq = int32(n)
+		}
+	}
+	return m
+}
+
+// session tracks the live encoder backing a client's logical recording, so
+// a reconnecting client can resume appending to it instead of starting a
+// new file. It also backs the Control service's session introspection.
+type session struct {
+	clientID    string
+	filename    string
+	startTime   time.Time
+	sampleRate  int32
+	numChannels int32
+	format      encoder.Format
+
+	enc       encoder.Encoder
+	chunkSeq  int32
+	frames    int64
+	sizeBytes int64
+}
+
+// info renders sess as the SessionInfo the Control service exposes.
+func (sess *session) info(sessionID string) *service.SessionInfo {
+	return &service.SessionInfo{
+		SessionId:            sessionID,
+		ClientId:             sess.clientID,
+		Filename:             sess.filename,
+		StartTimeUnixSeconds: sess.startTime.Unix(),
+		DurationMs:           sess.durationMs(),
+		SampleRate:           sess.sampleRate,
+		NumChannels:          sess.numChannels,
+		Encoder:              string(sess.format),
+		SizeBytes:            sess.sizeBytes,
+	}
+}
+
+func (sess *session) durationMs() int64 {
+	if sess.sampleRate == 0 || sess.numChannels == 0 {
+		return 0
+	}
+	framesPerChannel := float64(sess.frames) / float64(sess.numChannels)
+	return int64(framesPerChannel / float64(sess.sampleRate) * 1000)
+}
+
 type server struct {
-	prefix     string
-	mu         sync.Mutex
-	numClients int
+	prefix        string
+	defaultFormat encoder.Format
+	metrics       *interceptors.Metrics
+	mu            sync.Mutex
+	numClients    int
+	sessions      map[string]*session
+}
+
+// encoderFor resolves the format requested by a stream's header, falling
+// back to the server's configured default.
+func (s *server) encoderFor(requested string) (encoder.Factory, encoder.Format, error) {
+	f := encoder.Format(requested)
+	if f == "" {
+		f = s.defaultFormat
+	}
+	factory, err := encoder.FactoryFor(f)
+	if err != nil {
+		return nil, "", err
+	}
+	return factory, f, nil
 }
 
-func (s *server) newRecording(numChannels int32, sampleRate int32) (*sndfile.File, error) {
+// newRecording returns the encoder to use for meta, resuming an existing
+// session's encoder when resumeSeq is set and known, or creating a new
+// file under a per-client subdirectory otherwise.
+func (s *server) newRecording(meta streamMeta, numChannels, sampleRate int32, requestedFormat string) (encoder.Encoder, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	info := &sndfile.Info{
-		Channels:   numChannels,
-		Samplerate: sampleRate,
-		Format:     sndfile.SF_FORMAT_WAV | sndfile.SF_FORMAT_PCM_16,
+
+	if meta.resumeSeq >= 0 {
+		if sess, ok := s.sessions[meta.sessionID]; ok {
+			log.Printf("resuming session %s from chunk %d (client=%s device=%q)", meta.sessionID, meta.resumeSeq, meta.clientID, meta.deviceName)
+			sess.chunkSeq = meta.chunkSeq
+			return sess.enc, nil
+		}
+		log.Printf("WARNING: resume requested for unknown session %s; starting a new one", meta.sessionID)
+	}
+
+	factory, f, err := s.encoderFor(requestedFormat)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.prefix, meta.clientID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.%s", meta.sessionID, meta.chunkSeq, f.Extension()))
+	log.Printf("new recording: session=%s chunk=%d client=%s device=%q -> %s", meta.sessionID, meta.chunkSeq, meta.clientID, meta.deviceName, path)
+	out, err := factory.New(path, numChannels, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	if s.sessions == nil {
+		s.sessions = map[string]*session{}
+	}
+	// A session's encoder is only ever reused above, when the client asks to
+	// resume a session the server still knows; reaching here means any previous entry under this
+	// sessionID (e.g. the prior chunk, once it's rotated past) is done and
+	// would otherwise leak its file descriptor and leave its WAV/FLAC
+	// header unfinalized, since that's only written on Close.
+	if prev, ok := s.sessions[meta.sessionID]; ok {
+		if err := prev.enc.Close(); err != nil {
+			log.Printf("ERROR: couldn't close previous encoder for session %s: %v", meta.sessionID, err)
+		}
+	}
+	s.sessions[meta.sessionID] = &session{
+		clientID:    meta.clientID,
+		filename:    path,
+		startTime:   time.Now(),
+		sampleRate:  sampleRate,
+		numChannels: numChannels,
+		format:      f,
+		enc:         out,
+		chunkSeq:    meta.chunkSeq,
 	}
-	out, err := sndfile.Open(fmt.Sprintf("%s-%s-%03d.wav", s.prefix, time.Now().Format("2006-01-02-15-04-05"), s.numClients), sndfile.Write, info)
 	s.numClients += 1
-	return out, err
+	return out, nil
+}
+
+// closeSession finalizes and drops sessionID's encoder, provided it's still
+// the one the caller observed (it may already have been replaced by a
+// newer chunk via newRecording). Close errors are logged, not returned,
+// since by this point there's no RPC left to fail.
+func (s *server) closeSession(sessionID string, enc encoder.Encoder) {
+	s.mu.Lock()
+	if sess, ok := s.sessions[sessionID]; ok && sess.enc == enc {
+		delete(s.sessions, sessionID)
+	}
+	s.mu.Unlock()
+	if err := enc.Close(); err != nil {
+		log.Printf("ERROR: couldn't close encoder for session %s: %v", sessionID, err)
+	}
+}
+
+// closeAllSessions closes every still-open session's encoder; it's called
+// on shutdown so a killed server doesn't leave files truncated or their
+// descriptors held open.
+func (s *server) closeAllSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.sessions {
+		if err := sess.enc.Close(); err != nil {
+			log.Printf("ERROR: couldn't close session %s on shutdown: %v", id, err)
+		}
+	}
+	s.sessions = map[string]*session{}
+}
+
+// recordWrite updates the bookkeeping Control reports on, after nSamples
+// have successfully been appended to sessionID's encoder.
+func (s *server) recordWrite(sessionID string, nSamples int) {
+	s.mu.Lock()
+	sess, ok := s.sessions[sessionID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	sess.frames += int64(nSamples)
+	filename := sess.filename
+	s.mu.Unlock()
+
+	// size_bytes reports the encoder's current on-disk size, which depends
+	// on the format (e.g. FLAC/Opus compression, WAV headers) and can't be
+	// derived from the sample count alone, so stat the output file rather
+	// than approximating it from nSamples.
+	info, err := os.Stat(filename)
+	if err != nil {
+		log.Printf("ERROR: couldn't stat %q for session %s: %v", filename, sessionID, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sessions[sessionID]; ok {
+		sess.sizeBytes = info.Size()
+	}
+}
+
+// newUtteranceSplitter returns a vad.Splitter that opens a new
+// "<prefix>-<timestamp>-utt<NNN>.wav" file for each utterance it detects.
+// Any error from opening, writing or closing those files is reported
+// through *errOut, since Splitter's callbacks can't return one directly.
+func (s *server) newUtteranceSplitter(meta streamMeta, numChannels, sampleRate int32, errOut *error) *vad.Splitter {
+	factory, _ := encoder.FactoryFor(encoder.FormatWAVPCM16)
+	var uttIdx int
+	var cur encoder.Encoder
+	return vad.NewSplitter(vad.DefaultConfig, int(numChannels), sampleRate, vad.Callbacks{
+		StartUtterance: func() {
+			path := fmt.Sprintf("%s-%s-utt%03d.wav", s.prefix, time.Now().Format("2006-01-02-15-04-05"), uttIdx)
+			uttIdx++
+			enc, err := factory.New(path, numChannels, sampleRate)
+			if err != nil {
+				*errOut = err
+				return
+			}
+			log.Printf("new utterance: client=%s device=%q -> %s", meta.clientID, meta.deviceName, path)
+			cur = enc
+		},
+		Samples: func(samples []float32) {
+			if cur == nil || *errOut != nil {
+				return
+			}
+			if _, err := cur.Write(samples); err != nil {
+				if s.metrics != nil {
+					s.metrics.EncoderErrors.Inc()
+				}
+				*errOut = err
+			}
+		},
+		EndUtterance: func() {
+			if cur == nil {
+				return
+			}
+			if err := cur.Close(); err != nil && *errOut == nil {
+				*errOut = err
+			}
+			cur = nil
+		},
+	})
 }
 
 func (s *server) Record(srv service.Recorder_RecordServer) error {
-	var out *sndfile.File
+	meta := streamMetaFromContext(srv.Context())
+	log.Printf("stream started: client=%s device=%q session=%s chunk=%d", meta.clientID, meta.deviceName, meta.sessionID, meta.chunkSeq)
+
+	var out encoder.Encoder
+	var splitter *vad.Splitter
+	var splitErr error
+	var recvErr error
+
 	for {
 		in, err := srv.Recv()
 		if err != nil {
-			log.Printf("Stream error: %v", err)
+			recvErr = err
+			if err != io.EOF {
+				log.Printf("Stream error: %v", err)
+			}
 			break
 		}
-		if out == nil {
-			out, err = s.newRecording(in.GetHeader().GetNumChannels(), int32(in.GetHeader().GetSampleRate()))
-			if err != nil {
-				return err
+		if out == nil && splitter == nil {
+			numChannels := in.GetHeader().GetNumChannels()
+			sampleRate := int32(in.GetHeader().GetSampleRate())
+			if *vadEnabled || in.GetHeader().GetVad() {
+				splitter = s.newUtteranceSplitter(meta, numChannels, sampleRate, &splitErr)
+			} else {
+				out, err = s.newRecording(meta, numChannels, sampleRate, in.GetHeader().GetFormat())
+				if err != nil {
+					return err
+				}
+				// Sessions stay open across stream ends so a client that
+				// reconnects with resume-from-seq can keep appending to the
+				// same file. They're only closed below once the stream ends
+				// for a reason that rules that out (io.EOF, meaning the
+				// client called CloseSend deliberately rather than dropping
+				// mid-chunk), when superseded by the next chunk's encoder
+				// in newRecording, or on server shutdown.
 			}
-			defer out.Close()
 		}
 		if len(in.GetSample()) > 0 {
-			if _, err = out.WriteFrames(in.GetSample()); err != nil {
-				return err
+			switch {
+			case splitter != nil:
+				splitter.Write(in.GetSample())
+				if splitErr != nil {
+					return splitErr
+				}
+			case out != nil:
+				if _, err = out.Write(in.GetSample()); err != nil {
+					if s.metrics != nil {
+						s.metrics.EncoderErrors.Inc()
+					}
+					return err
+				}
+				s.recordWrite(meta.sessionID, len(in.GetSample()))
 			}
 		}
 		log.Printf("Got request with %d samples: %d bytes", len(in.GetSample()), proto.Size(in))
 	}
+	if splitter != nil {
+		splitter.Close()
+	}
+	if out != nil && recvErr == io.EOF {
+		s.closeSession(meta.sessionID, out)
+	}
+	return splitErr
+}
+
+// ListSessions streams a SessionInfo for every recording the server
+// currently holds open.
+func (s *server) ListSessions(_ *service.ListSessionsRequest, stream service.Control_ListSessionsServer) error {
+	s.mu.Lock()
+	infos := make([]*service.SessionInfo, 0, len(s.sessions))
+	for id, sess := range s.sessions {
+		infos = append(infos, sess.info(id))
+	}
+	s.mu.Unlock()
+
+	for _, info := range infos {
+		if err := stream.Send(info); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// GetSession returns the SessionInfo for a single recording.
+func (s *server) GetSession(_ context.Context, req *service.GetSessionRequest) (*service.SessionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[req.GetSessionId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown session %q", req.GetSessionId())
+	}
+	return sess.info(req.GetSessionId()), nil
+}
+
 func main() {
 	flag.Parse()
 
@@ -78,8 +427,51 @@ func main() {
 		log.Fatalf("could not listen on %q: %v", *bind, err)
 	}
 
-	s := grpc.NewServer(grpc.Creds(creds))
-	service.RegisterRecorderServer(s, &server{prefix: "rec"})
+	metrics := interceptors.NewMetrics()
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		log.Printf("serving metrics on %q", *metricsBind)
+		if err := http.ListenAndServe(*metricsBind, mux); err != nil {
+			log.Printf("ERROR: metrics server: %v", err)
+		}
+	}()
+
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		interceptors.LoggingStreamInterceptor(),
+		metrics.StreamInterceptor(),
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		interceptors.LoggingUnaryInterceptor(),
+		metrics.UnaryInterceptor(),
+	}
+	if *authTokens != "" {
+		tokens := strings.Split(*authTokens, ",")
+		streamInterceptors = append([]grpc.StreamServerInterceptor{interceptors.AuthStreamInterceptor(tokens)}, streamInterceptors...)
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{interceptors.AuthUnaryInterceptor(tokens)}, unaryInterceptors...)
+	}
+
+	s := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.KeepaliveEnforcementPolicy(keepaliveEnforcement),
+		grpc.KeepaliveParams(keepaliveParams),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+	)
+	srv := &server{prefix: "rec", defaultFormat: encoder.Format(*format), metrics: metrics}
+	service.RegisterRecorderServer(s, srv)
+	service.RegisterControlServer(s, srv)
+	reflection.Register(s)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		log.Printf("received %v, shutting down", sig)
+		s.GracefulStop()
+		srv.closeAllSessions()
+	}()
+
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("could not serve: %v", err)
 	}
@@ -0,0 +1,176 @@
+package vad
+
+import "time"
+
+// ring is a fixed-capacity FIFO over raw samples, used to keep the most
+// recent PreRoll worth of audio around in case speech starts.
+type ring struct {
+	data []float32
+	cap  int
+}
+
+func newRing(cap int) *ring {
+	return &ring{cap: cap}
+}
+
+func (r *ring) push(samples []float32) {
+	if r.cap <= 0 {
+		return
+	}
+	r.data = append(r.data, samples...)
+	if excess := len(r.data) - r.cap; excess > 0 {
+		r.data = r.data[excess:]
+	}
+}
+
+func (r *ring) snapshot() []float32 {
+	out := make([]float32, len(r.data))
+	copy(out, r.data)
+	return out
+}
+
+func (r *ring) reset() {
+	r.data = r.data[:0]
+}
+
+// Callbacks receives the utterance boundaries and audio a Splitter
+// produces.
+type Callbacks struct {
+	// StartUtterance is called once a new output file should be opened.
+	StartUtterance func()
+	// Samples is called with audio belonging to the current utterance,
+	// including any PreRoll/PostRoll padding.
+	Samples func([]float32)
+	// EndUtterance is called once the current output file should be
+	// closed.
+	EndUtterance func()
+}
+
+// Splitter consumes a continuous stream of interleaved samples and drives
+// Callbacks to split it into per-utterance files at silence boundaries.
+type Splitter struct {
+	cfg      Config
+	detector *Detector
+
+	frameSize int // samples per VAD frame, across all channels
+	buf       []float32
+
+	preroll *ring
+	tail    []float32
+
+	inUtterance bool
+	silenceRun  time.Duration
+	tailSilence time.Duration
+
+	cb Callbacks
+}
+
+// NewSplitter returns a Splitter for a stream with the given channel count
+// and sample rate.
+func NewSplitter(cfg Config, numChannels int, sampleRate int32, cb Callbacks) *Splitter {
+	frameLen := int(float64(sampleRate) * cfg.FrameDuration.Seconds())
+	if frameLen < 1 {
+		frameLen = 1
+	}
+	frameSize := frameLen * numChannels
+	prerollFrames := int(cfg.PreRoll / cfg.FrameDuration)
+	return &Splitter{
+		cfg:       cfg,
+		detector:  NewDetector(cfg),
+		frameSize: frameSize,
+		preroll:   newRing(prerollFrames * frameSize),
+		cb:        cb,
+		// Assume the stream starts during silence, so an utterance can
+		// begin as soon as speech is seen.
+		silenceRun: cfg.MinSilence,
+	}
+}
+
+// Write feeds newly received samples through the detector, buffering any
+// partial VAD frame until enough samples accumulate.
+func (s *Splitter) Write(samples []float32) {
+	s.buf = append(s.buf, samples...)
+	for len(s.buf) >= s.frameSize {
+		frame := s.buf[:s.frameSize]
+		s.processFrame(frame)
+		s.buf = append([]float32(nil), s.buf[s.frameSize:]...)
+	}
+}
+
+func (s *Splitter) processFrame(frame []float32) {
+	speech := s.detector.IsSpeech(frame)
+	switch {
+	case !s.inUtterance && speech:
+		if s.silenceRun < s.cfg.MinSilence {
+			// Not enough leading silence yet to call this an utterance.
+			return
+		}
+		s.startUtterance()
+		s.emit(frame)
+	case !s.inUtterance && !speech:
+		s.silenceRun += s.cfg.FrameDuration
+		s.preroll.push(frame)
+	case s.inUtterance && speech:
+		if len(s.tail) > 0 {
+			s.emit(s.tail)
+			s.tail = nil
+		}
+		s.tailSilence = 0
+		s.emit(frame)
+	case s.inUtterance && !speech:
+		s.tail = append(s.tail, frame...)
+		s.tailSilence += s.cfg.FrameDuration
+		if s.tailSilence >= s.cfg.MinSilence {
+			s.endUtteranceWithTail()
+		}
+	}
+}
+
+func (s *Splitter) startUtterance() {
+	s.inUtterance = true
+	s.tailSilence = 0
+	if s.cb.StartUtterance != nil {
+		s.cb.StartUtterance()
+	}
+	s.emit(s.preroll.snapshot())
+	s.preroll.reset()
+}
+
+func (s *Splitter) endUtteranceWithTail() {
+	postRollFrames := int(s.cfg.PostRoll / s.cfg.FrameDuration)
+	postRollSamples := postRollFrames * s.frameSize
+	if postRollSamples > len(s.tail) {
+		postRollSamples = len(s.tail)
+	}
+	s.emit(s.tail[:postRollSamples])
+	s.tail = nil
+	s.inUtterance = false
+	if s.cb.EndUtterance != nil {
+		s.cb.EndUtterance()
+	}
+	// An utterance just ended on a full MinSilence run, so the next one is
+	// allowed to start immediately.
+	s.silenceRun = s.cfg.MinSilence
+}
+
+func (s *Splitter) emit(samples []float32) {
+	if len(samples) == 0 || s.cb.Samples == nil {
+		return
+	}
+	s.cb.Samples(samples)
+}
+
+// Close flushes any utterance still open when the stream ends.
+func (s *Splitter) Close() {
+	if !s.inUtterance {
+		return
+	}
+	if len(s.tail) > 0 {
+		s.emit(s.tail)
+		s.tail = nil
+	}
+	s.inUtterance = false
+	if s.cb.EndUtterance != nil {
+		s.cb.EndUtterance()
+	}
+}
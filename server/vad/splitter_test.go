@@ -0,0 +1,144 @@
+package vad
+
+import (
+	"testing"
+	"time"
+)
+
+// testConfig uses small enough windows that a handful of frames exercise
+// every boundary, at a sample rate low enough to keep frame sizes tiny.
+var testConfig = Config{
+	FrameDuration: 20 * time.Millisecond,
+	MinSilence:    100 * time.Millisecond, // 5 frames
+	PreRoll:       40 * time.Millisecond,  // 2 frames
+	PostRoll:      40 * time.Millisecond,  // 2 frames
+	Threshold:     3.0,
+}
+
+const testSampleRate = 1000 // 20 samples/frame at 20ms
+
+func silenceFrame() []float32 {
+	return make([]float32, testSampleRate*20/1000)
+}
+
+func toneFrame() []float32 {
+	frame := make([]float32, testSampleRate*20/1000)
+	for i := range frame {
+		frame[i] = 0.5
+	}
+	return frame
+}
+
+// utterance collects what a Splitter reported for one utterance.
+type utterance struct {
+	samples []float32
+}
+
+func runSplitter(frames [][]float32) []utterance {
+	var utterances []utterance
+	var current *utterance
+	cb := Callbacks{
+		StartUtterance: func() {
+			current = &utterance{}
+		},
+		Samples: func(s []float32) {
+			if current != nil {
+				current.samples = append(current.samples, s...)
+			}
+		},
+		EndUtterance: func() {
+			if current != nil {
+				utterances = append(utterances, *current)
+				current = nil
+			}
+		},
+	}
+	sp := NewSplitter(testConfig, 1, testSampleRate, cb)
+	for _, f := range frames {
+		sp.Write(f)
+	}
+	sp.Close()
+	return utterances
+}
+
+func repeat(frame func() []float32, n int) [][]float32 {
+	out := make([][]float32, n)
+	for i := range out {
+		out[i] = frame()
+	}
+	return out
+}
+
+func concat(groups ...[][]float32) [][]float32 {
+	var out [][]float32
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
+
+func TestSplitterSilenceOnly(t *testing.T) {
+	frames := repeat(silenceFrame, 20)
+	utterances := runSplitter(frames)
+	if len(utterances) != 0 {
+		t.Fatalf("got %d utterances, want 0", len(utterances))
+	}
+}
+
+func TestSplitterSingleBurst(t *testing.T) {
+	frames := concat(
+		repeat(silenceFrame, 10),
+		repeat(toneFrame, 10),
+		repeat(silenceFrame, 10),
+	)
+	utterances := runSplitter(frames)
+	if len(utterances) != 1 {
+		t.Fatalf("got %d utterances, want 1", len(utterances))
+	}
+	// 2 preroll frames + 10 speech frames + 2 postroll frames, 20 samples each.
+	wantSamples := (2 + 10 + 2) * 20
+	if len(utterances[0].samples) != wantSamples {
+		t.Errorf("utterance length = %d samples, want %d", len(utterances[0].samples), wantSamples)
+	}
+}
+
+func TestSplitterTwoBursts(t *testing.T) {
+	frames := concat(
+		repeat(silenceFrame, 10),
+		repeat(toneFrame, 10),
+		repeat(silenceFrame, 10),
+		repeat(toneFrame, 10),
+		repeat(silenceFrame, 10),
+	)
+	utterances := runSplitter(frames)
+	if len(utterances) != 2 {
+		t.Fatalf("got %d utterances, want 2", len(utterances))
+	}
+}
+
+func TestSplitterShortGapDoesNotSplit(t *testing.T) {
+	// A silence gap shorter than MinSilence (100ms = 5 frames) must not
+	// end the utterance.
+	frames := concat(
+		repeat(silenceFrame, 10),
+		repeat(toneFrame, 5),
+		repeat(silenceFrame, 2), // only 40ms - below MinSilence
+		repeat(toneFrame, 5),
+		repeat(silenceFrame, 10),
+	)
+	utterances := runSplitter(frames)
+	if len(utterances) != 1 {
+		t.Fatalf("got %d utterances, want 1 (short gap shouldn't split)", len(utterances))
+	}
+}
+
+func TestSplitterOpenAtStreamEnd(t *testing.T) {
+	frames := concat(
+		repeat(silenceFrame, 10),
+		repeat(toneFrame, 10),
+	)
+	utterances := runSplitter(frames)
+	if len(utterances) != 1 {
+		t.Fatalf("got %d utterances, want 1 (Close should flush the open utterance)", len(utterances))
+	}
+}
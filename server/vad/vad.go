@@ -0,0 +1,97 @@
+// Package vad implements a simple energy-based voice-activity detector
+// used to split a single recording stream into per-utterance files at
+// silence boundaries.
+package vad
+
+import (
+	"math"
+	"time"
+)
+
+// Config controls the VAD and the utterance boundaries it produces.
+type Config struct {
+	// FrameDuration is the window RMS is computed over.
+	FrameDuration time.Duration
+	// MinSilence is how long a contiguous non-speech run must last before
+	// it's allowed to end an utterance, or before a speech frame is
+	// allowed to start one.
+	MinSilence time.Duration
+	// PreRoll/PostRoll pad the start/end of an utterance with audio drawn
+	// from just before/after the speech itself.
+	PreRoll  time.Duration
+	PostRoll time.Duration
+	// Threshold is the multiple of the noise floor a frame's RMS must
+	// exceed to be classified as speech.
+	Threshold float64
+}
+
+// DefaultConfig matches the project's historical VAD defaults.
+var DefaultConfig = Config{
+	FrameDuration: 20 * time.Millisecond,
+	MinSilence:    500 * time.Millisecond,
+	PreRoll:       200 * time.Millisecond,
+	PostRoll:      200 * time.Millisecond,
+	Threshold:     3.0,
+}
+
+// noiseFloorWindow is how often the moving noise-floor estimate is
+// refreshed from the minimum RMS seen since the last refresh.
+const noiseFloorWindow = time.Second
+
+// noiseFloorAlpha weights the latest window's minimum against the
+// estimate's history.
+const noiseFloorAlpha = 0.2
+
+// minNoiseFloor avoids a zero noise floor (which would classify any
+// non-zero signal as speech) before the first window completes.
+const minNoiseFloor = 1e-6
+
+// Detector classifies successive fixed-size frames as speech or silence
+// against a moving noise-floor estimate.
+type Detector struct {
+	cfg Config
+
+	noiseFloor  float64
+	windowMin   float64
+	windowSoFar time.Duration
+}
+
+// NewDetector returns a Detector configured by cfg.
+func NewDetector(cfg Config) *Detector {
+	return &Detector{
+		cfg:        cfg,
+		noiseFloor: minNoiseFloor,
+		windowMin:  math.MaxFloat64,
+	}
+}
+
+// rms computes the root-mean-square of frame.
+func rms(frame []float32) float64 {
+	if len(frame) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range frame {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(frame)))
+}
+
+// IsSpeech classifies one frame's worth of samples and updates the moving
+// noise-floor estimate.
+func (d *Detector) IsSpeech(frame []float32) bool {
+	r := rms(frame)
+	if r < d.windowMin {
+		d.windowMin = r
+	}
+	d.windowSoFar += d.cfg.FrameDuration
+	if d.windowSoFar >= noiseFloorWindow {
+		d.noiseFloor = noiseFloorAlpha*d.windowMin + (1-noiseFloorAlpha)*d.noiseFloor
+		if d.noiseFloor < minNoiseFloor {
+			d.noiseFloor = minNoiseFloor
+		}
+		d.windowMin = math.MaxFloat64
+		d.windowSoFar = 0
+	}
+	return r > d.noiseFloor*d.cfg.Threshold
+}